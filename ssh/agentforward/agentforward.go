@@ -0,0 +1,151 @@
+// Package agentforward wires OpenSSH agent forwarding
+// (auth-agent-req@openssh.com / auth-agent@openssh.com) on top of
+// golang.org/x/crypto/ssh and its ssh/agent subpackage.
+//
+// This glue cannot live in package ssh itself: ssh/agent already imports
+// ssh (for PublicKey, Signer and Marshal), so ssh importing ssh/agent back
+// would be an import cycle. Keeping it in its own leaf package, which
+// imports both, avoids that while still giving callers the same
+// Client/agent.Agent shaped API the request described.
+package agentforward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/moriyoshi/crypto/ssh"
+	"github.com/moriyoshi/crypto/ssh/agent"
+)
+
+// forwarding tracks, per *ssh.Client, the agent.Agent that serves inbound
+// auth-agent@openssh.com channels opened by the server once Request has
+// been called. Callers must call Forget once a Client has disconnected, or
+// the entry (and the *ssh.Client it keys on) is never released.
+var forwarding sync.Map // map[*ssh.Client]agent.Agent
+
+// Request sends the auth-agent-req@openssh.com channel request on session
+// and arranges for any subsequent auth-agent@openssh.com channel the
+// server opens on c to be served by ag via agent.ServeAgent. It is the
+// client-side half of OpenSSH's agent forwarding extension, implemented
+// the same way package ssh already implements
+// streamlocal-forward@openssh.com in streamlocal.go.
+func Request(c *ssh.Client, session *ssh.Session, ag agent.Agent) error {
+	if ag == nil {
+		return errors.New("ssh/agentforward: Request requires a non-nil agent")
+	}
+
+	if _, loaded := forwarding.LoadOrStore(c, ag); !loaded {
+		serveForwardedAgent(c.HandleChannelOpen("auth-agent@openssh.com"), ag)
+	}
+
+	ok, err := ssh.SessionChannel(session).SendRequest("auth-agent-req@openssh.com", true, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("ssh/agentforward: auth-agent-req@openssh.com request denied by peer")
+	}
+	return nil
+}
+
+// serveForwardedAgent accepts every inbound auth-agent@openssh.com channel
+// delivered on channels and serves ag over each one via agent.ServeAgent,
+// until channels is closed. It is split out from Request so the
+// channel-serving behavior can be exercised directly in tests against a
+// fake channel source, without needing a real *ssh.Client.
+func serveForwardedAgent(channels <-chan ssh.NewChannel, ag agent.Agent) {
+	go func() {
+		for newCh := range channels {
+			ch, reqs, err := newCh.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func(ch ssh.Channel) {
+				defer ch.Close()
+				agent.ServeAgent(ag, ch)
+			}(ch)
+		}
+	}()
+}
+
+// Forget removes c's forwarded agent, if any. Callers must invoke it once c
+// has disconnected (mirroring ssh.ForwardedUnixHandler.HandleConnClose's
+// pattern) so that a long-running process making many connections — a
+// bastion or proxy, say — does not pin every *ssh.Client it has ever seen
+// in the package-level registry above.
+func Forget(c *ssh.Client) {
+	forwarding.Delete(c)
+}
+
+// Policy decides whether a session on conn may forward its agent to the
+// server.
+type Policy func(ctx context.Context, conn ssh.Conn) bool
+
+// Handler implements the server side of OpenSSH's agent forwarding
+// extension: granting auth-agent-req@openssh.com channel requests, and
+// dialing the resulting auth-agent@openssh.com channel back to the client
+// to obtain an agent.ExtendedAgent for use by session commands.
+type Handler struct {
+	// Policy, if set, is consulted before a session's
+	// auth-agent-req@openssh.com request is granted. A nil Policy permits
+	// every request.
+	Policy Policy
+}
+
+// NewHandler returns a Handler with no policy restriction.
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// HandleRequest handles an auth-agent-req@openssh.com channel request
+// received on a session channel. Callers are expected to dispatch to it
+// from the loop draining the <-chan *ssh.Request returned alongside a
+// session's NewChannel.Accept, e.g.:
+//
+//	case "auth-agent-req@openssh.com":
+//		req.Reply(handler.HandleRequest(ctx, sconn, req), nil)
+func (h *Handler) HandleRequest(ctx context.Context, conn ssh.Conn, req *ssh.Request) bool {
+	if h.Policy != nil && !h.Policy(ctx, conn) {
+		return false
+	}
+	return true
+}
+
+// Dial opens an auth-agent@openssh.com channel back to the client and
+// wraps it as an agent.ExtendedAgent.
+func (h *Handler) Dial(conn ssh.Conn) (agent.ExtendedAgent, error) {
+	ch, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+
+	ext, ok := agent.NewClient(ch).(agent.ExtendedAgent)
+	if !ok {
+		ch.Close()
+		return nil, errors.New("ssh/agentforward: agent client does not implement ExtendedAgent")
+	}
+	return ext, nil
+}
+
+// ServeOnUnixSocket exposes the client's forwarded agent as a Unix socket
+// at socketPath, suitable for populating SSH_AUTH_SOCK for a forked shell
+// or command. It creates the socket through unix.ListenLocal, so unix's
+// BaseDir, SocketMode and stale-socket removal apply; the listener is not
+// tracked by unix, and the caller closes it through the returned
+// io.Closer. Unlike a forwarded-streamlocal listener, a fresh
+// auth-agent@openssh.com channel is opened for every local connection,
+// matching how OpenSSH itself proxies ssh-agent.
+func (h *Handler) ServeOnUnixSocket(unix *ssh.ForwardedUnixHandler, conn ssh.Conn, socketPath string) (io.Closer, error) {
+	return unix.ListenLocal(socketPath, func() (ssh.Channel, error) {
+		ch, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+		if err != nil {
+			return nil, err
+		}
+		go ssh.DiscardRequests(reqs)
+		return ch, nil
+	})
+}
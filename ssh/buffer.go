@@ -11,8 +11,8 @@ import (
 )
 
 // buffer provides a linked list buffer for data exchange
-// between producer and consumer. Theoretically the buffer is
-// of unlimited capacity as it does no allocation of its own.
+// between producer and consumer. Unless constructed with newBufferSize,
+// the buffer is of unlimited capacity as it does no allocation of its own.
 type buffer struct {
 	// protects concurrent access to head, tail and closed
 	opCh chan func()
@@ -20,9 +20,27 @@ type buffer struct {
 	// notifies the waiting reader that the pending ops have been completed.
 	finCh chan struct{}
 
+	// notify is signalled (non-blocking, best effort) whenever a Read call
+	// drains bytes, so that a writer blocked in WriteWithContext can recheck
+	// whether the buffer has room.
+	notify chan struct{}
+
 	head *element // the buffer that will be read first
 	tail *element // the buffer that will be read last
 
+	// max is the maximum number of in-flight bytes WriteWithContext will
+	// queue before blocking. Zero means unbounded.
+	max int
+	// size is the number of bytes currently queued between head and tail.
+	size int
+
+	// OnBlocked, if set, is called from WriteWithContext the first time a
+	// given call has to wait for the consumer to drain the buffer.
+	OnBlocked func(pending int)
+	// OnDrained, if set, is called after every Read that removes bytes
+	// from the buffer, reporting how many bytes were freed.
+	OnDrained func(n int)
+
 	mu     sync.Mutex
 	closed bool
 }
@@ -33,14 +51,34 @@ type element struct {
 	next *element
 }
 
-// newBuffer returns an empty buffer that is not closed.
+// newBuffer returns an empty, unbounded buffer that is not closed.
 func newBuffer() *buffer {
+	return newBufferSize(0)
+}
+
+// newBufferSize returns an empty buffer that is not closed, bounded to at
+// most max in-flight bytes. A max of zero or less behaves like newBuffer:
+// WriteWithContext never blocks for space. The bound is a credit scheme,
+// not a hard allocation limit: a single write larger than max is still
+// accepted once the buffer is empty, so that oversized messages can't
+// deadlock the producer.
+//
+// Note: this and WriteWithContext are the bounded-buffer primitive only.
+// No Channel yet constructs its buffer with newBufferSize instead of
+// newBuffer, and nothing yet ties draining to SSH_MSG_CHANNEL_WINDOW_ADJUST
+// — that wiring belongs in the channel/mux code this chunk does not
+// include. Until a Channel opts in by switching its buffer's constructor
+// and forwarding window adjustments through OnDrained, channels remain
+// unbounded exactly as before.
+func newBufferSize(max int) *buffer {
 	e := new(element)
 	b := &buffer{
-		opCh:  make(chan func()),
-		finCh: make(chan struct{}),
-		head:  e,
-		tail:  e,
+		opCh:   make(chan func()),
+		finCh:  make(chan struct{}),
+		notify: make(chan struct{}, 1),
+		head:   e,
+		tail:   e,
+		max:    max,
 	}
 	go func() {
 		for bop := range b.opCh {
@@ -59,11 +97,66 @@ func (b *buffer) write(buf []byte) {
 		e := &element{buf: buf}
 		b.tail.next = e
 		b.tail = e
+		b.size += len(buf)
 		doneCh <- struct{}{}
 	}
 	<-doneCh
 }
 
+// WriteWithContext behaves like write, except that on a buffer created via
+// newBufferSize it blocks until there is room for buf within the configured
+// byte budget, or until ctx is done. Credit is returned to blocked writers
+// as the consumer calls Read/ReadWithContext, giving true end-to-end
+// backpressure instead of queuing unboundedly.
+func (b *buffer) WriteWithContext(ctx context.Context, buf []byte) error {
+	blocked := false
+	for b.max > 0 {
+		fitCh := make(chan bool, 1)
+		b.opCh <- func() {
+			fitCh <- b.size == 0 || b.size+len(buf) <= b.max
+		}
+		if <-fitCh {
+			break
+		}
+		if !blocked {
+			blocked = true
+			if b.OnBlocked != nil {
+				b.OnBlocked(len(buf))
+			}
+		}
+		select {
+		case <-b.notify:
+		case <-b.finCh:
+			return io.ErrClosedPipe
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	b.write(buf)
+	return nil
+}
+
+// drain accounts for n bytes having been removed from the buffer by a Read,
+// waking any writer blocked in WriteWithContext and reporting the drained
+// bytes via OnDrained.
+func (b *buffer) drain(n int) {
+	if n <= 0 {
+		return
+	}
+	b.size -= n
+	if b.size < 0 {
+		b.size = 0
+	}
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	if b.OnDrained != nil {
+		b.OnDrained(n)
+	}
+}
+
 // eof closes the buffer. Reads from the buffer once all
 // the data has been consumed will receive io.EOF.
 func (b *buffer) eof() {
@@ -106,7 +199,9 @@ outer:
 		if !closed {
 			ch = make(chan int, 1)
 			b.opCh <- func() {
-				ch <- b.read(buf)
+				r := b.read(buf)
+				b.drain(r)
+				ch <- r
 			}
 		}
 		b.mu.Unlock()
@@ -120,6 +215,7 @@ outer:
 				break outer
 			}
 			n = b.read(buf)
+			b.drain(n)
 		} else {
 			select {
 			case n = <-ch:
@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBufferWriteWithContextBlocksWhenFull(t *testing.T) {
+	b := newBufferSize(4)
+	if err := b.WriteWithContext(context.Background(), []byte("ab")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+
+	blockedCh := make(chan struct{})
+	b.OnBlocked = func(pending int) { close(blockedCh) }
+
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- b.WriteWithContext(context.Background(), []byte("cdef"))
+	}()
+
+	select {
+	case <-blockedCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected WriteWithContext to block and report OnBlocked")
+	}
+
+	buf := make([]byte, 2)
+	n, err := b.ReadWithContext(context.Background(), buf)
+	if err != nil || n != 2 {
+		t.Fatalf("drain read: n=%d err=%v", n, err)
+	}
+
+	select {
+	case err := <-writeErr:
+		if err != nil {
+			t.Fatalf("blocked write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked write never unblocked after drain")
+	}
+}
+
+func TestBufferWriteWithContextCancel(t *testing.T) {
+	b := newBufferSize(2)
+	if err := b.WriteWithContext(context.Background(), []byte("ab")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.WriteWithContext(ctx, []byte("cd")); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestBufferOnDrainedReportsBytes(t *testing.T) {
+	b := newBufferSize(0)
+	var drained int
+	b.OnDrained = func(n int) { drained += n }
+
+	b.write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := b.ReadWithContext(context.Background(), buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if drained != 5 {
+		t.Fatalf("drained = %d, want 5", drained)
+	}
+}
+
+func TestBufferOversizedWriteDoesNotDeadlock(t *testing.T) {
+	b := newBufferSize(2)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.WriteWithContext(context.Background(), []byte("this is much bigger than 2"))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("oversized write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oversized write deadlocked instead of being let through on an empty buffer")
+	}
+}
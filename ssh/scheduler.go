@@ -0,0 +1,286 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// PriorityChannel is implemented by Channel values whose outbound frames
+// are arbitrated by a scheduler instead of being written to the transport
+// strictly FIFO. Without it, every channel on a connection drains its
+// buffer independently and a bulk transfer on one channel can head-of-line
+// block an interactive channel on another.
+//
+// Note: no Channel implements PriorityChannel yet, and mux's transport
+// writer does not call scheduler.next — that wiring touches the mux and
+// channel-writer code this chunk does not include. scheduler is a
+// self-contained, independently testable primitive in the meantime; a
+// mux adopting it would embed one scheduler, have each channel's Write
+// call enqueue instead of writing the transport directly, and have its
+// single transport-writing goroutine loop on next.
+type PriorityChannel interface {
+	Channel
+
+	// SetPriority sets the relative priority used to pick this channel's
+	// next outbound frame against those of other channels sharing the
+	// same connection. Higher values are served first, subject to a
+	// fairness quantum so lower-priority channels are never fully
+	// starved. The default priority is defaultPriority.
+	SetPriority(p uint8)
+
+	// SetRateLimit caps this channel's outbound byte rate. A limit of 0
+	// removes any existing cap.
+	SetRateLimit(bytesPerSecond int)
+}
+
+// defaultPriority is used by channels that have not called SetPriority.
+const defaultPriority uint8 = 128
+
+// schedQuantum bounds how many consecutive frames a single priority band
+// may emit before the scheduler gives a lower-priority band a turn.
+const schedQuantum = 16
+
+// frame is a single outbound payload queued by a channel for the transport
+// writer, tagged with the channel's priority at the time it was queued.
+type frame struct {
+	channelID uint32
+	priority  uint8
+	payload   []byte
+	done      chan<- error
+}
+
+// rateLimiter is a token bucket used to cap the byte rate of an individual
+// channel's outbound traffic.
+type rateLimiter struct {
+	mu        sync.Mutex
+	tokens    float64
+	max       float64
+	perSecond float64
+	last      time.Time
+}
+
+func newRateLimiter(bytesPerSecond int) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		tokens:    float64(bytesPerSecond),
+		max:       float64(bytesPerSecond),
+		perSecond: float64(bytesPerSecond),
+		last:      time.Now(),
+	}
+}
+
+// wait blocks the caller until n bytes worth of tokens are available,
+// refilling the bucket based on elapsed time since the last call.
+func (r *rateLimiter) wait(n int) {
+	if r == nil {
+		return
+	}
+	// A request larger than the bucket's burst size may accumulate up to n
+	// tokens, so it waits for n bytes' worth of refill instead of forever.
+	capacity := r.max
+	if float64(n) > capacity {
+		capacity = float64(n)
+	}
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * r.perSecond
+		if r.tokens > capacity {
+			r.tokens = capacity
+		}
+		r.last = now
+		if r.tokens >= float64(n) {
+			r.tokens -= float64(n)
+			r.mu.Unlock()
+			return
+		}
+		deficit := float64(n) - r.tokens
+		wait := time.Duration(deficit / r.perSecond * float64(time.Second))
+		r.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// scheduler arbitrates access to a single transport writer across every
+// channel multiplexed on one connection (inspired by smux's priority write
+// queue), so that a bulk SFTP transfer on one channel does not
+// head-of-line-block an interactive shell on another. Frames are picked
+// using a weighted/strict priority scheme: the highest-priority non-empty
+// queue is served first, but no queue may emit more than schedQuantum
+// consecutive frames, giving lower-priority queues a fairness window
+// instead of starving them outright. A mux embeds one scheduler and calls
+// enqueue from each PriorityChannel's Write and next from its single
+// transport-writing goroutine.
+type scheduler struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[uint8][]*frame // priority -> FIFO of pending frames
+	limits map[uint32]*rateLimiter
+	closed bool
+
+	// lastPriority/lastCount track the fairness quantum across calls to
+	// next: they count consecutive frames served from the same priority
+	// band so a continuously-busy high-priority channel still yields to a
+	// lower one every schedQuantum frames instead of starving it forever.
+	lastPriority uint8
+	lastCount    int
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{
+		queues: make(map[uint8][]*frame),
+		limits: make(map[uint32]*rateLimiter),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// setLimit installs a byte-per-second cap for channelID. A bytesPerSecond
+// of 0 or less removes any existing cap.
+func (s *scheduler) setLimit(channelID uint32, bytesPerSecond int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytesPerSecond <= 0 {
+		delete(s.limits, channelID)
+		return
+	}
+	s.limits[channelID] = newRateLimiter(bytesPerSecond)
+}
+
+// enqueue admits payload for channelID at priority into the scheduler,
+// applying channelID's rate limit (if any) before the frame ever joins a
+// priority queue, and returns a channel that receives the outcome of the
+// eventual write. enqueue blocks its caller — the channel's own writer —
+// for as long as the rate limit requires; it never blocks the transport
+// writer's call to next, so a throttled channel only slows itself down
+// instead of head-of-line-blocking every other channel multiplexed over
+// the same connection.
+func (s *scheduler) enqueue(channelID uint32, priority uint8, payload []byte) <-chan error {
+	done := make(chan error, 1)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		done <- io.ErrClosedPipe
+		return done
+	}
+	limit := s.limits[channelID]
+	s.mu.Unlock()
+
+	limit.wait(len(payload))
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		done <- io.ErrClosedPipe
+		return done
+	}
+	s.queues[priority] = append(s.queues[priority], &frame{
+		channelID: channelID,
+		priority:  priority,
+		payload:   payload,
+		done:      done,
+	})
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	return done
+}
+
+// next blocks until a frame is ready to send and returns it. It returns
+// ok=false once the scheduler has been closed and drained. Rate limiting
+// happens earlier, in enqueue, so next never blocks on anything but the
+// arrival of work: a single slow, throttled channel cannot stall frames
+// queued by any other channel.
+func (s *scheduler) next() (f *frame, ok bool) {
+	s.mu.Lock()
+	for {
+		p, has := s.highestNonEmptyLocked()
+		if !has {
+			if s.closed {
+				s.mu.Unlock()
+				return nil, false
+			}
+			s.cond.Wait()
+			continue
+		}
+
+		// Enforce the fairness quantum: once a priority band has emitted
+		// schedQuantum frames in a row, give the next-lower non-empty
+		// band a turn instead.
+		if p == s.lastPriority {
+			s.lastCount++
+		} else {
+			s.lastPriority, s.lastCount = p, 1
+		}
+		if s.lastCount > schedQuantum {
+			if alt, has := s.nextBelowLocked(p); has {
+				p = alt
+				s.lastPriority, s.lastCount = p, 1
+			}
+		}
+
+		q := s.queues[p]
+		f, q = q[0], q[1:]
+		if len(q) == 0 {
+			delete(s.queues, p)
+		} else {
+			s.queues[p] = q
+		}
+		s.mu.Unlock()
+
+		return f, true
+	}
+}
+
+func (s *scheduler) highestNonEmptyLocked() (uint8, bool) {
+	best := -1
+	for p, q := range s.queues {
+		if len(q) == 0 {
+			continue
+		}
+		if best == -1 || int(p) > best {
+			best = int(p)
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	return uint8(best), true
+}
+
+func (s *scheduler) nextBelowLocked(below uint8) (uint8, bool) {
+	best := -1
+	for p, q := range s.queues {
+		if len(q) == 0 || p >= below {
+			continue
+		}
+		if best == -1 || int(p) > best {
+			best = int(p)
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	return uint8(best), true
+}
+
+// close wakes any goroutine blocked in next, causing it to return ok=false,
+// and fails every frame still queued.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closed = true
+	pending := s.queues
+	s.queues = make(map[uint8][]*frame)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	for _, q := range pending {
+		for _, f := range q {
+			f.done <- io.ErrClosedPipe
+		}
+	}
+}
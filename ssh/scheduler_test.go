@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerPrioritizesHigherBand(t *testing.T) {
+	s := newScheduler()
+	s.enqueue(1, 10, []byte("low"))
+	s.enqueue(2, 200, []byte("high"))
+
+	f, ok := s.next()
+	if !ok || f.channelID != 2 {
+		t.Fatalf("got channel %d, want the higher-priority channel 2", f.channelID)
+	}
+}
+
+// TestSchedulerFairnessQuantum is a regression test for the bug fixed in
+// the commit immediately preceding this one: lastPriority/lastCount were
+// local to next(), so the fairness quantum could never trip and a busy
+// high-priority channel starved lower-priority ones forever.
+func TestSchedulerFairnessQuantum(t *testing.T) {
+	s := newScheduler()
+	for i := 0; i < 40; i++ {
+		s.enqueue(1, 200, []byte("hi"))
+	}
+	s.enqueue(2, 10, []byte("lo"))
+
+	for i := 0; i < schedQuantum; i++ {
+		f, ok := s.next()
+		if !ok || f.priority != 200 {
+			t.Fatalf("frame %d: got priority %d, want the high band to run out its quantum first", i, f.priority)
+		}
+	}
+
+	f, ok := s.next()
+	if !ok || f.priority != 10 {
+		t.Fatalf("after %d high-priority frames, got priority %d, want the low-priority channel to get a turn", schedQuantum, f.priority)
+	}
+}
+
+// TestSchedulerRateLimitDoesNotBlockOtherChannels is a regression test for
+// the bug fixed in the commit immediately preceding this one: the rate
+// limit used to be applied inside next(), so a throttled channel stalled
+// the single shared transport writer and, with it, every other channel's
+// frames.
+func TestSchedulerRateLimitDoesNotBlockOtherChannels(t *testing.T) {
+	s := newScheduler()
+	// Install a deliberately slow limiter directly, bypassing setLimit's int
+	// bytesPerSecond, so channel 1's enqueue starts from an empty bucket and
+	// has a bounded wait that outlasts the rest of this test.
+	s.mu.Lock()
+	s.limits[1] = &rateLimiter{max: 10, perSecond: 10, last: time.Now()}
+	s.mu.Unlock()
+
+	start := time.Now()
+	throttledDone := make(chan struct{})
+	go func() {
+		s.enqueue(1, defaultPriority, make([]byte, 50)) // needs ~5s worth of tokens
+		close(throttledDone)
+	}()
+
+	// Give the throttled enqueue time to start waiting on its rate limiter.
+	time.Sleep(50 * time.Millisecond)
+
+	otherDone := make(chan *frame, 1)
+	go func() {
+		if f, ok := s.next(); ok {
+			otherDone <- f
+		}
+	}()
+
+	s.enqueue(2, defaultPriority, []byte("hi"))
+
+	select {
+	case f := <-otherDone:
+		if f.channelID != 2 {
+			t.Fatalf("got channel %d, want channel 2", f.channelID)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("next() took %v to return channel 2's frame; channel 1's rate limit leaked into the shared loop", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("next() never returned channel 2's frame while channel 1 was still rate-limited")
+	}
+
+	select {
+	case <-throttledDone:
+		t.Fatal("rate-limited enqueue returned far sooner than its configured rate allows")
+	default:
+	}
+}
+
+func TestSchedulerSetLimitThrottlesEnqueue(t *testing.T) {
+	s := newScheduler()
+	s.setLimit(1, 10) // 10 bytes/sec, bucket starts full at 10 tokens
+
+	start := time.Now()
+	s.enqueue(1, defaultPriority, make([]byte, 5)) // fits in the initial burst
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("first enqueue within the burst took %v, want near-instant", elapsed)
+	}
+
+	start = time.Now()
+	s.enqueue(1, defaultPriority, make([]byte, 10)) // exceeds remaining tokens, must wait
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("second enqueue took %v, want it to wait for the rate limit to replenish", elapsed)
+	}
+
+	s.setLimit(1, 0) // remove the cap
+	start = time.Now()
+	s.enqueue(1, defaultPriority, make([]byte, 1000))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("enqueue after clearing the limit took %v, want near-instant", elapsed)
+	}
+}
+
+func TestSchedulerCloseDrainsPending(t *testing.T) {
+	s := newScheduler()
+	done := s.enqueue(1, defaultPriority, []byte("queued"))
+	s.close()
+
+	if err := <-done; err == nil {
+		t.Fatal("expected a pending frame to be failed when the scheduler closes")
+	}
+	if _, ok := s.next(); ok {
+		t.Fatal("next should report closed once drained")
+	}
+}
@@ -0,0 +1,11 @@
+package ssh
+
+// SessionChannel returns the Channel underlying session. It exists for
+// extensions — such as the agent-forwarding glue in ssh/agentforward —
+// that need to send additional channel requests (auth-agent-req@openssh.com,
+// in that case) alongside the ones Session itself sends, but cannot live in
+// package ssh: ssh/agent already imports ssh for PublicKey, Signer and
+// Marshal, so ssh importing ssh/agent back would be a cycle.
+func SessionChannel(session *Session) Channel {
+	return session.ch
+}
@@ -0,0 +1,269 @@
+// Package sshtest provides an in-memory net.Conn/net.Listener pair for
+// exercising code built on top of golang.org/x/crypto/ssh without touching
+// the network. It follows the same pattern as grpc's bufconn: Pipe returns
+// a pair of connected conns directly, and Listener's Accept is fed by an
+// in-process Dial, so an ssh.Server and ssh.NewClientConn can run in the
+// same process for deterministic unit tests (including of the channel and
+// forwarding logic) and for fuzzing the wire protocol.
+package sshtest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrClosedPipe is returned by Write once either end of a Pipe has been
+// closed, and by a Listener's Dial and Accept once it has been closed. Read
+// on a closed Pipe returns io.EOF instead, after any buffered data.
+var ErrClosedPipe = io.ErrClosedPipe
+
+// defaultBufferSize is used when Pipe or NewListener is given a
+// non-positive buffer size.
+const defaultBufferSize = 64 * 1024
+
+type addr string
+
+func (a addr) Network() string { return string(a) }
+func (a addr) String() string  { return string(a) }
+
+// ring is a bounded byte buffer shared between one conn's Write and the
+// peer conn's Read. Write blocks once bufferSize bytes are queued, giving
+// tests a way to exercise backpressure instead of the unbounded queueing of
+// net.Pipe. Each ring has exactly one writer and one reader, so its
+// deadlines live here rather than on conn: read and write re-check them
+// under r.mu on every wakeup, so a deadline changed while a call is already
+// blocked takes effect immediately instead of only on the next call.
+type ring struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	buf      []byte
+	max      int
+	closed   bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newRing(max int) *ring {
+	r := &ring{max: max}
+	r.notEmpty = sync.NewCond(&r.mu)
+	r.notFull = sync.NewCond(&r.mu)
+	return r
+}
+
+// wait blocks on cond, which must guard r.mu, until woken by a Broadcast.
+// If deadline is non-zero it also arms a timer that broadcasts cond once
+// deadline passes. setReadDeadline/setWriteDeadline broadcast the same
+// cond after updating the deadline field, so a waiter parked here wakes
+// immediately when its deadline changes, not just when it next elapses.
+func (r *ring) wait(cond *sync.Cond, deadline time.Time) {
+	if deadline.IsZero() {
+		cond.Wait()
+		return
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return
+	}
+	timer := time.AfterFunc(d, func() {
+		r.mu.Lock()
+		cond.Broadcast()
+		r.mu.Unlock()
+	})
+	cond.Wait()
+	timer.Stop()
+}
+
+func (r *ring) write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		if r.closed {
+			return written, ErrClosedPipe
+		}
+		if !r.writeDeadline.IsZero() && !time.Now().Before(r.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+		avail := r.max - len(r.buf)
+		if avail <= 0 {
+			r.wait(r.notFull, r.writeDeadline)
+			continue
+		}
+		n := avail
+		if n > len(p) {
+			n = len(p)
+		}
+		r.buf = append(r.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		r.notEmpty.Broadcast()
+	}
+	return written, nil
+}
+
+func (r *ring) read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.buf) == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+		if !r.readDeadline.IsZero() && !time.Now().Before(r.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		r.wait(r.notEmpty, r.readDeadline)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.notFull.Broadcast()
+	return n, nil
+}
+
+func (r *ring) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.notEmpty.Broadcast()
+		r.notFull.Broadcast()
+	}
+}
+
+// setReadDeadline updates the deadline applied by read and wakes any
+// reader already blocked so it re-checks it right away, instead of
+// waiting only for the next call to read.
+func (r *ring) setReadDeadline(t time.Time) {
+	r.mu.Lock()
+	r.readDeadline = t
+	r.notEmpty.Broadcast()
+	r.mu.Unlock()
+}
+
+// setWriteDeadline is setReadDeadline's write-side counterpart.
+func (r *ring) setWriteDeadline(t time.Time) {
+	r.mu.Lock()
+	r.writeDeadline = t
+	r.notFull.Broadcast()
+	r.mu.Unlock()
+}
+
+// conn is a net.Conn backed by two rings, one per direction. It implements
+// SetReadDeadline/SetWriteDeadline so that it satisfies the ssh package's
+// readerWithDeadlineSetter/writerWithDeadlineSetter interfaces.
+type conn struct {
+	r, w *ring
+}
+
+func (c *conn) Read(p []byte) (int, error)  { return c.r.read(p) }
+func (c *conn) Write(p []byte) (int, error) { return c.w.write(p) }
+
+// Close closes both directions of the conn. The peer observes io.EOF from
+// Read once it has drained any data already written, and ErrClosedPipe from
+// Write.
+func (c *conn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return addr("sshtest") }
+func (c *conn) RemoteAddr() net.Addr { return addr("sshtest") }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read, including a Read that is
+// already blocked: the ring wakes and re-checks the new deadline right
+// away, so setting one in the past interrupts an in-flight Read
+// immediately rather than only affecting the next call. That is what lets
+// ssh's tryCancelReader/tryCancelWriter (cancelable_bufio.go), which work
+// by setting a past deadline on an operation already in flight, be
+// exercised against a sshtest conn.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline is SetReadDeadline's write-side counterpart.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.w.setWriteDeadline(t)
+	return nil
+}
+
+// Pipe returns a pair of connected, in-memory net.Conn values, analogous to
+// net.Pipe but backed by bufferSize-byte bounded buffers in each direction:
+// a Write blocks once that many bytes are queued for the peer, rather than
+// synchronizing with a matching Read the way net.Pipe does. A non-positive
+// bufferSize uses a 64KiB default. Both ends support SetReadDeadline and
+// SetWriteDeadline.
+func Pipe(bufferSize int) (net.Conn, net.Conn) {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	a2b := newRing(bufferSize)
+	b2a := newRing(bufferSize)
+	return &conn{r: b2a, w: a2b}, &conn{r: a2b, w: b2a}
+}
+
+// Listener is an in-process net.Listener whose Accept is fed by Dial,
+// mirroring grpc's bufconn.Listener: it lets a test spin up an ssh.Server
+// and ssh.NewClientConn in the same process without touching the network.
+type Listener struct {
+	bufferSize int
+
+	ch        chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns a Listener whose connections are backed by
+// bufferSize-byte bounded buffers in each direction; see Pipe.
+func NewListener(bufferSize int) *Listener {
+	return &Listener{
+		bufferSize: bufferSize,
+		ch:         make(chan net.Conn),
+		done:       make(chan struct{}),
+	}
+}
+
+// Dial creates a new in-process connection pair and delivers the server
+// half to a pending or future call to Accept.
+func (l *Listener) Dial() (net.Conn, error) {
+	client, server := Pipe(l.bufferSize)
+	select {
+	case l.ch <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrClosedPipe
+	}
+}
+
+// Accept waits for and returns the next connection created by Dial.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ch:
+		return c, nil
+	case <-l.done:
+		return nil, ErrClosedPipe
+	}
+}
+
+// Close stops future Dial and Accept calls from succeeding.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the listener's address, which is not meaningful beyond
+// identifying it as an in-memory listener.
+func (l *Listener) Addr() net.Addr { return addr("sshtest") }
@@ -0,0 +1,164 @@
+package sshtest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPipeRoundTrip(t *testing.T) {
+	a, b := Pipe(64)
+	msg := []byte("hello world")
+	go func() {
+		if _, err := a.Write(msg); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("got %q, want %q", buf, msg)
+	}
+}
+
+func TestPipeBlocksWhenFull(t *testing.T) {
+	a, b := Pipe(4)
+	done := make(chan error, 1)
+	go func() { _, err := a.Write([]byte("abcdefgh")); done <- err }()
+
+	select {
+	case <-done:
+		t.Fatal("write of 8 bytes into a 4 byte buffer returned before any Read")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	buf := make([]byte, 8)
+	n, err := io.ReadFull(b, buf)
+	if err != nil || n != 8 {
+		t.Fatalf("read: n=%d err=%v", n, err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write never unblocked once the buffer was drained")
+	}
+}
+
+func TestPipeCloseUnblocksPeer(t *testing.T) {
+	a, b := Pipe(4)
+	a.Close()
+
+	buf := make([]byte, 1)
+	if _, err := b.Read(buf); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	if _, err := b.Write(buf); err != ErrClosedPipe {
+		t.Fatalf("got %v, want ErrClosedPipe", err)
+	}
+}
+
+func TestPipeDeadline(t *testing.T) {
+	a, _ := Pipe(4)
+	a.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	buf := make([]byte, 1)
+	if _, err := a.Read(buf); err == nil {
+		t.Fatal("expected a deadline error")
+	}
+}
+
+// TestSetReadDeadlineInterruptsBlockedRead is a regression test for the bug
+// fixed in the commit immediately preceding this one: SetReadDeadline used
+// to only take effect on the next call to Read, so moving the deadline of
+// an already-blocked Read into the past had no effect — unlike
+// cancelable_bufio.go's tryCancelReader, which relies on exactly that
+// mid-flight cancellation.
+func TestSetReadDeadlineInterruptsBlockedRead(t *testing.T) {
+	a, _ := Pipe(4)
+
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := a.Read(buf)
+		readErr <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the Read block on an empty pipe
+
+	start := time.Now()
+	a.SetReadDeadline(time.Now().Add(-time.Second))
+
+	select {
+	case err := <-readErr:
+		if err != os.ErrDeadlineExceeded {
+			t.Fatalf("got %v, want os.ErrDeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("Read took %v to notice the new deadline; want near-instant", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Read never woke up after its deadline was moved into the past")
+	}
+}
+
+// TestSetWriteDeadlineInterruptsBlockedWrite is SetReadDeadline's write-side
+// counterpart above.
+func TestSetWriteDeadlineInterruptsBlockedWrite(t *testing.T) {
+	a, _ := Pipe(4)
+	a.Write([]byte("abcd")) // fill the buffer so the next Write blocks
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := a.Write([]byte("e"))
+		writeErr <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the Write block on a full pipe
+
+	start := time.Now()
+	a.SetWriteDeadline(time.Now().Add(-time.Second))
+
+	select {
+	case err := <-writeErr:
+		if err != os.ErrDeadlineExceeded {
+			t.Fatalf("got %v, want os.ErrDeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("Write took %v to notice the new deadline; want near-instant", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Write never woke up after its deadline was moved into the past")
+	}
+}
+
+func TestListener(t *testing.T) {
+	l := NewListener(16)
+	defer l.Close()
+
+	go func() {
+		c, err := l.Dial()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c.Write([]byte("hi"))
+	}()
+
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(s, buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,326 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// UnixForwardPolicy decides whether a streamlocal-forward@openssh.com or
+// direct-streamlocal@openssh.com request for socketPath should be honored
+// for the given conn. It is consulted before a listener is created or a
+// local socket is dialed.
+type UnixForwardPolicy func(ctx context.Context, conn Conn, socketPath string) bool
+
+// ForwardedUnixHandler implements the server side of the
+// streamlocal-forward@openssh.com / cancel-streamlocal-forward@openssh.com
+// global requests and the forwarded-streamlocal@openssh.com channel type.
+// It is the server-side counterpart to the client support in
+// streamlocal.go: where Client.ListenUnix lets a client ask a server to
+// forward a remote Unix socket, ForwardedUnixHandler lets a server built on
+// this package grant such requests.
+//
+// A single ForwardedUnixHandler may be shared by every connection accepted
+// by a server; listeners are keyed by the session together with the
+// requested path, so a session can only cancel, and HandleConnClose only
+// closes, that session's own forwards. The socket file is not per session:
+// when a second session forwards a path that resolves to the same file,
+// the first session's socket file is removed and replaced.
+type ForwardedUnixHandler struct {
+	// BaseDir, if non-empty, constrains every socketPath to a path rooted
+	// at BaseDir. Requests for paths outside BaseDir are rejected before
+	// Policy is consulted.
+	BaseDir string
+
+	// SocketMode, if non-zero, is applied to each listener socket after
+	// it is created (os.Chmod), working around the umask applied by
+	// net.Listen("unix", ...).
+	SocketMode os.FileMode
+
+	// Policy, if set, is consulted for both forwarding and cancellation
+	// requests. A nil Policy permits everything. DirectStreamLocalHandler
+	// does not read this field or BaseDir; it takes its policy as an
+	// argument.
+	Policy UnixForwardPolicy
+
+	mu       sync.Mutex
+	forwards map[unixForwardKey]net.Listener
+}
+
+type unixForwardKey struct {
+	sessionID string
+	path      string
+}
+
+// NewForwardedUnixHandler returns a ForwardedUnixHandler with no path
+// restrictions and no policy; callers typically set BaseDir, SocketMode
+// and/or Policy on the returned value before use.
+func NewForwardedUnixHandler() *ForwardedUnixHandler {
+	return &ForwardedUnixHandler{
+		forwards: make(map[unixForwardKey]net.Listener),
+	}
+}
+
+func (h *ForwardedUnixHandler) key(conn Conn, path string) unixForwardKey {
+	return unixForwardKey{sessionID: string(conn.SessionID()), path: path}
+}
+
+func (h *ForwardedUnixHandler) resolve(path string) (string, error) {
+	if h.BaseDir == "" {
+		return path, nil
+	}
+	full := filepath.Join(h.BaseDir, path)
+	rel, err := filepath.Rel(h.BaseDir, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("ssh: socket path %q escapes base directory", path)
+	}
+	return full, nil
+}
+
+// HandleRequest handles the streamlocal-forward@openssh.com and
+// cancel-streamlocal-forward@openssh.com global requests. Callers are
+// expected to dispatch to it from the loop that drains the <-chan *Request
+// returned by NewServerConn, e.g.:
+//
+//	for req := range reqs {
+//		switch req.Type {
+//		case "streamlocal-forward@openssh.com", "cancel-streamlocal-forward@openssh.com":
+//			ok, payload := handler.HandleRequest(ctx, sconn, req)
+//			req.Reply(ok, payload)
+//		default:
+//			...
+//		}
+//	}
+func (h *ForwardedUnixHandler) HandleRequest(ctx context.Context, conn Conn, req *Request) (bool, []byte) {
+	var m streamLocalChannelForwardMsg
+	if err := Unmarshal(req.Payload, &m); err != nil {
+		return false, nil
+	}
+	if _, err := h.resolve(m.socketPath); err != nil {
+		return false, nil
+	}
+
+	switch req.Type {
+	case "streamlocal-forward@openssh.com":
+		if h.Policy != nil && !h.Policy(ctx, conn, m.socketPath) {
+			return false, nil
+		}
+		if err := h.listen(ctx, conn, m.socketPath); err != nil {
+			return false, nil
+		}
+		return true, nil
+	case "cancel-streamlocal-forward@openssh.com":
+		if h.Policy != nil && !h.Policy(ctx, conn, m.socketPath) {
+			return false, nil
+		}
+		if err := h.cancel(conn, m.socketPath); err != nil {
+			return false, nil
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (h *ForwardedUnixHandler) listen(ctx context.Context, conn Conn, socketPath string) error {
+	resolved, err := h.resolve(socketPath)
+	if err != nil {
+		return err
+	}
+
+	key := h.key(conn, socketPath)
+	h.mu.Lock()
+	if _, ok := h.forwards[key]; ok {
+		h.mu.Unlock()
+		return fmt.Errorf("ssh: %q is already being forwarded", socketPath)
+	}
+	h.mu.Unlock()
+
+	// Remove a stale socket left behind by a process that did not clean
+	// up after itself; net.Listen fails with EADDRINUSE otherwise.
+	if fi, err := os.Stat(resolved); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(resolved)
+	}
+
+	listener, err := net.Listen("unix", resolved)
+	if err != nil {
+		return err
+	}
+	if h.SocketMode != 0 {
+		os.Chmod(resolved, h.SocketMode)
+	}
+
+	h.mu.Lock()
+	h.forwards[key] = listener
+	h.mu.Unlock()
+
+	go h.accept(conn, socketPath, listener)
+	return nil
+}
+
+func (h *ForwardedUnixHandler) cancel(conn Conn, socketPath string) error {
+	key := h.key(conn, socketPath)
+	h.mu.Lock()
+	listener, ok := h.forwards[key]
+	if ok {
+		delete(h.forwards, key)
+	}
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("ssh: %q is not being forwarded", socketPath)
+	}
+	return listener.Close()
+}
+
+// HandleConnClose removes and closes every listener opened on behalf of
+// conn. Callers should invoke it once the underlying connection has gone
+// away so that forwarded sockets do not leak.
+func (h *ForwardedUnixHandler) HandleConnClose(conn Conn) {
+	prefix := string(conn.SessionID())
+	h.mu.Lock()
+	var listeners []net.Listener
+	for key, listener := range h.forwards {
+		if key.sessionID == prefix {
+			listeners = append(listeners, listener)
+			delete(h.forwards, key)
+		}
+	}
+	h.mu.Unlock()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+func (h *ForwardedUnixHandler) accept(conn Conn, socketPath string, listener net.Listener) {
+	acceptLoop(listener, func(c net.Conn) {
+		payload := Marshal(&forwardedStreamLocalPayload{SocketPath: socketPath})
+		pipeUnixToChannel(c, func() (Channel, error) {
+			ch, reqs, err := conn.OpenChannel("forwarded-streamlocal@openssh.com", payload)
+			if err != nil {
+				return nil, err
+			}
+			go DiscardRequests(reqs)
+			return ch, nil
+		})
+	})
+}
+
+// ListenLocal creates a Unix listener at socketPath, subject to the same
+// BaseDir/SocketMode handling as HandleRequest, and for every local
+// connection it accepts, calls open to obtain a Channel to pipe it to.
+// Unlike HandleRequest, the returned listener is not tied to a
+// streamlocal-forward@openssh.com request or keyed by session; the caller
+// owns the returned io.Closer. Agent forwarding uses this to expose
+// SSH_AUTH_SOCK locally, opening a fresh auth-agent@openssh.com channel per
+// local connection.
+func (h *ForwardedUnixHandler) ListenLocal(socketPath string, open func() (Channel, error)) (io.Closer, error) {
+	resolved, err := h.resolve(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi, err := os.Stat(resolved); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(resolved)
+	}
+
+	listener, err := net.Listen("unix", resolved)
+	if err != nil {
+		return nil, err
+	}
+	if h.SocketMode != 0 {
+		os.Chmod(resolved, h.SocketMode)
+	}
+
+	go acceptLoop(listener, func(c net.Conn) {
+		pipeUnixToChannel(c, open)
+	})
+	return listener, nil
+}
+
+func acceptLoop(listener net.Listener, handle func(net.Conn)) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handle(c)
+	}
+}
+
+// pipeUnixToChannel dials a fresh Channel via open and pipes c to it until
+// either side is done, then closes both.
+func pipeUnixToChannel(c net.Conn, open func() (Channel, error)) {
+	defer c.Close()
+
+	ch, err := open()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(ch, c)
+		ch.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(c, ch)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// DirectStreamLocalHandler handles an incoming direct-streamlocal@openssh.com
+// channel open request by dialing socketPath on the local machine and
+// piping the channel to it. Policy, if non-nil, may reject the dial.
+func DirectStreamLocalHandler(ctx context.Context, conn Conn, newChannel NewChannel, policy UnixForwardPolicy) error {
+	var msg streamLocalChannelOpenDirectMsg
+	if err := Unmarshal(newChannel.ExtraData(), &msg); err != nil {
+		newChannel.Reject(ConnectionFailed, "invalid direct-streamlocal@openssh.com payload")
+		return err
+	}
+
+	if policy != nil && !policy(ctx, conn, msg.socketPath) {
+		newChannel.Reject(Prohibited, "direct-streamlocal@openssh.com forwarding is disabled")
+		return errors.New("ssh: direct-streamlocal@openssh.com rejected by policy")
+	}
+
+	dialed, err := net.Dial("unix", msg.socketPath)
+	if err != nil {
+		newChannel.Reject(ConnectionFailed, err.Error())
+		return err
+	}
+
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		dialed.Close()
+		return err
+	}
+	go DiscardRequests(reqs)
+
+	go func() {
+		defer dialed.Close()
+		defer ch.Close()
+
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(ch, dialed)
+			ch.CloseWrite()
+			done <- struct{}{}
+		}()
+		go func() {
+			io.Copy(dialed, ch)
+			done <- struct{}{}
+		}()
+		<-done
+	}()
+
+	return nil
+}
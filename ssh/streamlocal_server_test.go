@@ -0,0 +1,105 @@
+package ssh
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// fakeForwardConn is a minimal Conn fake for the tests below: every
+// ForwardedUnixHandler method under test only calls SessionID on its Conn
+// argument.
+type fakeForwardConn struct {
+	Conn
+	sessionID string
+}
+
+func (f fakeForwardConn) SessionID() []byte { return []byte(f.sessionID) }
+
+func TestResolveRejectsPathEscapingBaseDir(t *testing.T) {
+	h := &ForwardedUnixHandler{BaseDir: "/srv/sockets"}
+
+	if _, err := h.resolve("../../etc/passwd"); err == nil {
+		t.Fatal("expected an error for a path escaping BaseDir")
+	}
+	if _, err := h.resolve(".."); err == nil {
+		t.Fatal("expected an error for \"..\" itself")
+	}
+	want := filepath.Join("/srv/sockets", "agent.sock")
+	if got, err := h.resolve("agent.sock"); err != nil || got != want {
+		t.Fatalf("got (%q, %v), want (%q, nil)", got, err, want)
+	}
+}
+
+func TestListenRejectsDoubleForwardOfSamePath(t *testing.T) {
+	h := NewForwardedUnixHandler()
+	h.BaseDir = t.TempDir()
+	conn := fakeForwardConn{sessionID: "session-1"}
+
+	if err := h.listen(context.Background(), conn, "agent.sock"); err != nil {
+		t.Fatalf("first listen: %v", err)
+	}
+	defer h.HandleConnClose(conn)
+
+	if err := h.listen(context.Background(), conn, "agent.sock"); err == nil {
+		t.Fatal("expected the second forward of the same path to be rejected")
+	}
+}
+
+// TestCancelHonorsPolicy is a regression test for the bug fixed a few
+// commits before this one: HandleRequest's cancel-streamlocal-forward
+// branch did not consult Policy before tearing the forward down.
+func TestCancelHonorsPolicy(t *testing.T) {
+	h := NewForwardedUnixHandler()
+	h.BaseDir = t.TempDir()
+	conn := fakeForwardConn{sessionID: "session-1"}
+
+	if err := h.listen(context.Background(), conn, "agent.sock"); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer h.HandleConnClose(conn)
+
+	h.Policy = func(ctx context.Context, conn Conn, socketPath string) bool { return false }
+	req := &Request{
+		Type:    "cancel-streamlocal-forward@openssh.com",
+		Payload: Marshal(&streamLocalChannelForwardMsg{socketPath: "agent.sock"}),
+	}
+	ok, _ := h.HandleRequest(context.Background(), conn, req)
+	if ok {
+		t.Fatal("expected HandleRequest to deny cancellation when Policy returns false")
+	}
+
+	h.mu.Lock()
+	_, stillForwarded := h.forwards[h.key(conn, "agent.sock")]
+	h.mu.Unlock()
+	if !stillForwarded {
+		t.Fatal("cancellation proceeded despite Policy denying it")
+	}
+}
+
+func TestHandleConnCloseClosesListeners(t *testing.T) {
+	h := NewForwardedUnixHandler()
+	h.BaseDir = t.TempDir()
+	conn := fakeForwardConn{sessionID: "session-1"}
+
+	if err := h.listen(context.Background(), conn, "agent.sock"); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	h.mu.Lock()
+	listener := h.forwards[h.key(conn, "agent.sock")]
+	h.mu.Unlock()
+
+	h.HandleConnClose(conn)
+
+	h.mu.Lock()
+	_, stillForwarded := h.forwards[h.key(conn, "agent.sock")]
+	h.mu.Unlock()
+	if stillForwarded {
+		t.Fatal("HandleConnClose left the forward in the map")
+	}
+
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("expected the listener to be closed")
+	}
+}